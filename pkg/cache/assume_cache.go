@@ -0,0 +1,187 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"strconv"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// objInfo tracks what a caller has optimistically assumed about a PV. For a
+// created-but-not-yet-observed PV, latestObj is the real object returned by
+// the create call, and the assumption is confirmed once the informer
+// reports that same version (or newer). For a pending-deletion marker,
+// pendingDelete is set instead, and the assumption is only ever cleared by
+// an actual observed deletion (OnInformerDelete) or an explicit Restore —
+// never by an unrelated update to the PV arriving first.
+type objInfo struct {
+	name          string
+	latestObj     *v1.PersistentVolume
+	pendingDelete bool
+}
+
+// AssumeCache lets the Discoverer and Deleter optimistically record changes
+// to a PV before the VolumeCache's informer has observed them, analogous to
+// the scheduler's volume-binding assume cache. Assume records a PV right
+// after it is created, so a directory scan that runs before the watch fires
+// sees it and doesn't attempt to create it again; AssumeDeletion marks a PV
+// as pending deletion, so a cleanup loop can skip it until the delete is
+// observed.
+//
+// Assumptions are invalidated by informer events, not by reads: wire
+// VolumeCache.SetAssumeCache to a cache constructed with NewAssumeCache(store)
+// and its Add/Update/Delete methods (the ones the informer drives) call
+// OnInformerUpdate/OnInformerDelete below to drop assumptions the informer
+// has since caught up to.
+//
+// This type is not yet in use: there is no Discoverer or Deleter in this
+// source tree to call Assume/AssumeDeletion, and nothing constructs an
+// AssumeCache or calls SetAssumeCache. The create/re-discover race this was
+// meant to close stays open until that loop exists and is wired in.
+type AssumeCache struct {
+	mutex sync.RWMutex
+
+	// store is kept up to date by whatever drives its Add/Update/Delete
+	// methods (an informer).
+	store *VolumeCache
+
+	// pvInfo holds an entry for every PV that has been assumed and not yet
+	// reconciled against the informer's view.
+	pvInfo map[string]*objInfo
+}
+
+// NewAssumeCache creates an AssumeCache backed by store.
+func NewAssumeCache(store *VolumeCache) *AssumeCache {
+	return &AssumeCache{
+		store:  store,
+		pvInfo: map[string]*objInfo{},
+	}
+}
+
+// Assume records pv as the latest version of the named PV, ahead of the
+// VolumeCache's informer observing it. The assumption is dropped once the
+// informer reports a version of the PV at least as new as pv itself.
+func (c *AssumeCache) Assume(pv *v1.PersistentVolume) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pvInfo[pv.Name] = &objInfo{
+		name:      pv.Name,
+		latestObj: pv,
+	}
+	klog.V(4).Infof("Assumed pv %q", pv.Name)
+}
+
+// AssumeDeletion marks pvName as pending deletion, ahead of the informer
+// observing it actually being deleted. Unlike Assume, this assumption is
+// never dropped by an unrelated update to the PV — only an observed
+// deletion (OnInformerDelete) or an explicit Restore clears it, since a
+// pending-deletion marker has no "confirming" resourceVersion to wait for.
+func (c *AssumeCache) AssumeDeletion(pvName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pvInfo[pvName] = &objInfo{
+		name:          pvName,
+		pendingDelete: true,
+	}
+	klog.V(4).Infof("Assumed pv %q is pending deletion", pvName)
+}
+
+// Restore discards the assumed version of pvName, if any, reverting GetPV to
+// whatever the VolumeCache currently holds. Callers use this when the change
+// they optimistically assumed turns out not to happen, e.g. PV creation or
+// deletion fails after Assume/AssumeDeletion was called.
+func (c *AssumeCache) Restore(pvName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.pvInfo[pvName]; !ok {
+		klog.V(5).Infof("Restore pv %q: didn't exist", pvName)
+		return
+	}
+	delete(c.pvInfo, pvName)
+	klog.V(4).Infof("Restored pv %q", pvName)
+}
+
+// GetPV returns the assumed version of pvName if one is still pending, and
+// otherwise falls back to the VolumeCache. Assumptions are dropped by
+// OnInformerUpdate/OnInformerDelete as informer events arrive, not here, so a
+// PV that's assumed and never looked up again doesn't leak.
+func (c *AssumeCache) GetPV(pvName string) (*v1.PersistentVolume, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if info, assumed := c.pvInfo[pvName]; assumed {
+		return info.latestObj, !info.pendingDelete
+	}
+	return c.store.GetPV(pvName)
+}
+
+// OnInformerUpdate is called by VolumeCache.Add/Update whenever the
+// informer adds or updates a PV. It drops a creation assumption once the
+// informer's version catches up to the assumed object itself (not to
+// whatever the store held before Assume was called, which could be an
+// arbitrarily older, unrelated version). Pending-deletion assumptions are
+// left alone here: they're only cleared by an observed deletion or an
+// explicit Restore, since an unrelated update to the PV (e.g. another
+// controller touching an annotation) says nothing about whether the
+// deletion has actually happened.
+func (c *AssumeCache) OnInformerUpdate(pv *v1.PersistentVolume) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	info, assumed := c.pvInfo[pv.Name]
+	if !assumed || info.pendingDelete {
+		return
+	}
+	if resourceVersion(pv) >= resourceVersion(info.latestObj) {
+		// The informer has caught up to (or moved past) the version we
+		// assumed, so the assumption is confirmed.
+		delete(c.pvInfo, pv.Name)
+		klog.V(4).Infof("Assumed pv %q is confirmed by informer, dropping assumption", pv.Name)
+	}
+}
+
+// OnInformerDelete is called by VolumeCache.Delete whenever the informer
+// observes a PV's deletion, so a pending assumption for it — in particular
+// the Deleter's pending-deletion marker — doesn't outlive the object.
+func (c *AssumeCache) OnInformerDelete(pvName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, assumed := c.pvInfo[pvName]; assumed {
+		delete(c.pvInfo, pvName)
+		klog.V(4).Infof("Assumed pv %q was deleted, dropping assumption", pvName)
+	}
+}
+
+// resourceVersion parses a PV's resourceVersion as an int64, returning 0 if
+// it can't be parsed (e.g. the PV is nil or hasn't been persisted yet).
+func resourceVersion(pv *v1.PersistentVolume) int64 {
+	if pv == nil {
+		return 0
+	}
+	v, err := strconv.ParseInt(pv.ResourceVersion, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestPV(name, resourceVersion string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			ResourceVersion: resourceVersion,
+		},
+	}
+}
+
+func alwaysTrue(obj interface{}) bool { return true }
+
+func TestAssumeCacheGetPVReturnsAssumedVersion(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	assumeCache := NewAssumeCache(store)
+
+	pv := newTestPV("pv1", "10")
+	assumeCache.Assume(pv)
+
+	got, exists := assumeCache.GetPV("pv1")
+	if !exists {
+		t.Fatalf("GetPV(pv1): expected to exist")
+	}
+	if got != pv {
+		t.Fatalf("GetPV(pv1) = %v, want the assumed object %v", got, pv)
+	}
+}
+
+func TestAssumeCacheGetPVFallsBackToStore(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	assumeCache := NewAssumeCache(store)
+
+	if _, exists := assumeCache.GetPV("missing"); exists {
+		t.Fatalf("GetPV(missing): expected not to exist")
+	}
+
+	pv := newTestPV("pv1", "10")
+	store.AddPV(pv)
+
+	got, exists := assumeCache.GetPV("pv1")
+	if !exists || got != pv {
+		t.Fatalf("GetPV(pv1) = %v, %v, want %v, true", got, exists, pv)
+	}
+}
+
+func TestAssumeCacheRestore(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	assumeCache := NewAssumeCache(store)
+
+	assumeCache.Assume(newTestPV("pv1", "10"))
+	assumeCache.Restore("pv1")
+
+	if _, exists := assumeCache.GetPV("pv1"); exists {
+		t.Fatalf("GetPV(pv1): expected not to exist after Restore")
+	}
+
+	// Restoring an unassumed PV is a no-op, not an error.
+	assumeCache.Restore("pv1")
+}
+
+func TestAssumeCacheOnInformerUpdateDropsStaleAssumption(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	assumeCache := NewAssumeCache(store)
+	store.SetAssumeCache(assumeCache)
+
+	assumed := newTestPV("pv1", "10")
+	assumeCache.Assume(assumed)
+
+	// The informer hasn't caught up yet: the store is still at the same
+	// resourceVersion the PV had when assumed (none, in this case).
+	if got, exists := assumeCache.GetPV("pv1"); !exists || got != assumed {
+		t.Fatalf("GetPV(pv1) before informer update = %v, %v, want %v, true", got, exists, assumed)
+	}
+
+	// The informer now observes the PV at a newer resourceVersion: our own
+	// write has landed, so the assumption should be dropped without ever
+	// calling GetPV again.
+	confirmed := newTestPV("pv1", "11")
+	store.Add(confirmed)
+
+	got, exists := assumeCache.GetPV("pv1")
+	if !exists || got != confirmed {
+		t.Fatalf("GetPV(pv1) after informer update = %v, %v, want %v, true", got, exists, confirmed)
+	}
+}
+
+func TestAssumeCacheOnInformerUpdateKeepsFreshAssumption(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	assumeCache := NewAssumeCache(store)
+	store.SetAssumeCache(assumeCache)
+
+	// Seed the store at resourceVersion 10 before assuming a newer object.
+	store.AddPV(newTestPV("pv1", "10"))
+
+	assumed := newTestPV("pv1", "11")
+	assumeCache.Assume(assumed)
+
+	// An unrelated informer re-sync at the same resourceVersion shouldn't
+	// drop the assumption.
+	store.Update(newTestPV("pv1", "10"))
+
+	got, exists := assumeCache.GetPV("pv1")
+	if !exists || got != assumed {
+		t.Fatalf("GetPV(pv1) = %v, %v, want the still-pending assumption %v, true", got, exists, assumed)
+	}
+}
+
+func TestAssumeCacheOnInformerDeleteDropsAssumption(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	assumeCache := NewAssumeCache(store)
+	store.SetAssumeCache(assumeCache)
+
+	pv := newTestPV("pv1", "10")
+	store.AddPV(pv)
+	assumeCache.AssumeDeletion("pv1")
+
+	store.Delete(pv)
+
+	if _, exists := assumeCache.GetPV("pv1"); exists {
+		t.Fatalf("GetPV(pv1): expected not to exist after informer delete")
+	}
+}
+
+// TestAssumeCacheAssumeDeletionSurvivesUnrelatedUpdate reproduces the
+// Deleter's pending-deletion use case: an assumed deletion must not be
+// dropped by an unrelated informer update to the same PV (e.g. another
+// controller bumping an annotation) arriving before the actual delete is
+// observed. Comparing against the pre-assumption snapshot of the object
+// (rather than treating pending-deletion as its own state, cleared only by
+// OnInformerDelete/Restore) would drop the assumption here and re-open the
+// race this is meant to close.
+func TestAssumeCacheAssumeDeletionSurvivesUnrelatedUpdate(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	assumeCache := NewAssumeCache(store)
+	store.SetAssumeCache(assumeCache)
+
+	pv := newTestPV("pv1", "10")
+	store.AddPV(pv)
+	assumeCache.AssumeDeletion("pv1")
+
+	// An unrelated update bumps the resourceVersion without the object
+	// actually being deleted.
+	store.Update(newTestPV("pv1", "11"))
+
+	if _, exists := assumeCache.GetPV("pv1"); exists {
+		t.Fatalf("GetPV(pv1): expected the pending-deletion assumption to survive an unrelated update")
+	}
+
+	// The actual deletion is what clears it.
+	store.Delete(newTestPV("pv1", "11"))
+	if _, exists := assumeCache.GetPV("pv1"); exists {
+		t.Fatalf("GetPV(pv1): expected not to exist once the deletion is observed")
+	}
+}
+
+func TestAssumeCacheAssumeDeletionClearedByRestore(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	assumeCache := NewAssumeCache(store)
+
+	pv := newTestPV("pv1", "10")
+	store.AddPV(pv)
+	assumeCache.AssumeDeletion("pv1")
+
+	assumeCache.Restore("pv1")
+
+	got, exists := assumeCache.GetPV("pv1")
+	if !exists || got != pv {
+		t.Fatalf("GetPV(pv1) after Restore = %v, %v, want %v, true", got, exists, pv)
+	}
+}
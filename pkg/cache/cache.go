@@ -17,31 +17,182 @@ limitations under the License.
 package cache
 
 import (
+	"fmt"
+	"sync"
+
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 
 	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/sig-storage-local-static-provisioner/pkg/metrics"
+)
+
+// nodeLabelKey is the well-known node affinity key the provisioner uses to
+// pin a local PV to the node it was discovered on.
+const nodeLabelKey = "kubernetes.io/hostname"
+
+const (
+	// nodeIndex indexes PVs by the node names referenced in their required
+	// node affinity terms.
+	nodeIndex = "node"
+	// storageClassIndex indexes PVs by their storage class name.
+	storageClassIndex = "storageClass"
+	// hostPathIndex indexes PVs by their local host path.
+	hostPathIndex = "hostPath"
 )
 
 // VolumeCache keeps all the PersistentVolumes that have been created by this provisioner.
 // It is periodically updated by the Populator.
-// The Deleter and Discoverer use the VolumeCache to check on created PVs
+// The Deleter and Discoverer use the VolumeCache to check on created PVs.
+// In addition to a flat list, it maintains secondary indexes on node,
+// storage class and host path, exposed via ListPVsByNode/
+// ListPVsByStorageClass/GetPVByHostPath, so a caller that needs the PVs for
+// one node or mountpoint doesn't have to scan the whole cache. As of this
+// change the Deleter, Discoverer and Populator in this source tree don't
+// exist, so nothing calls these lookups yet; ListPVs' O(N) scan is still
+// what every caller in this tree actually uses.
 type VolumeCache struct {
-	pvStore    cache.Store
+	pvStore    cache.Indexer
 	filterFunc func(obj interface{}) bool
 	keyFunc    cache.KeyFunc
+
+	// claimCache, if set via SetClaimCache, backs GetBoundPVC.
+	claimCache *ClaimCache
+
+	// assumeCache, if set via SetAssumeCache, is notified by Add/Update/
+	// Delete (the informer-driven methods) so it can drop assumptions the
+	// informer has caught up to.
+	assumeCache *AssumeCache
+
+	// mu guards snapshotPaths and metricsState below. Unlike pvStore, which
+	// is a cache.Indexer and synchronizes itself, these maps are touched by
+	// both the informer's reflector goroutine (Add/Update/Delete/Replace)
+	// and the Discoverer/Deleter goroutines (AddPV/UpdatePV/DeletePV), so
+	// they need their own lock.
+	mu sync.Mutex
+
+	// snapshotPaths holds host-path-to-PV-name entries loaded from a
+	// SnapshotStore by LoadSnapshot. It's consulted by HasDiscoveredPath
+	// until Replace is called with the informer's authoritative list, at
+	// which point it's discarded.
+	snapshotPaths map[string]string
+
+	// metricsState tracks the (mode, storageclass, phase, capacity) last
+	// reported to the metrics package for each PV, so Add/Update/Delete can
+	// adjust the PersistentVolumeCount/CapacityBytes gauges incrementally
+	// instead of rescanning the whole cache on every event.
+	metricsState map[string]pvMetricsState
+}
+
+// pvMetricsState is the set of labels/values a PV contributes to the
+// PersistentVolumeCount and CapacityBytes metrics.
+type pvMetricsState struct {
+	mode         string
+	storageClass string
+	phase        string
+	capacity     int64
+}
+
+func pvMetricsStateFor(pv *v1.PersistentVolume) pvMetricsState {
+	mode := string(v1.PersistentVolumeFilesystem)
+	if pv.Spec.VolumeMode != nil {
+		mode = string(*pv.Spec.VolumeMode)
+	}
+	var capacity int64
+	if qty, ok := pv.Spec.Capacity[v1.ResourceStorage]; ok {
+		capacity = qty.Value()
+	}
+	return pvMetricsState{
+		mode:         mode,
+		storageClass: pv.Spec.StorageClassName,
+		phase:        string(pv.Status.Phase),
+		capacity:     capacity,
+	}
 }
 
 // NewVolumeCache creates a new PV cache object for storing PVs created by this provisioner.
 func NewVolumeCache(filterFunc func(obj interface{}) bool) *VolumeCache {
 	keyFunc := cache.DeletionHandlingMetaNamespaceKeyFunc
 	return &VolumeCache{
-		pvStore:    cache.NewStore(keyFunc),
-		filterFunc: filterFunc,
-		keyFunc:    keyFunc,
+		pvStore: cache.NewIndexer(keyFunc, cache.Indexers{
+			nodeIndex:         nodeIndexFunc,
+			storageClassIndex: storageClassIndexFunc,
+			hostPathIndex:     hostPathIndexFunc,
+		}),
+		filterFunc:   filterFunc,
+		keyFunc:      keyFunc,
+		metricsState: map[string]pvMetricsState{},
 	}
 }
 
+// nodeIndexFunc indexes a PV by the node names referenced in its required
+// node affinity terms.
+func nodeIndexFunc(obj interface{}) ([]string, error) {
+	pv, ok := obj.(*v1.PersistentVolume)
+	if !ok {
+		return nil, fmt.Errorf("object is not a PersistentVolume: %v", obj)
+	}
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return nil, nil
+	}
+	var nodes []string
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == nodeLabelKey && expr.Operator == v1.NodeSelectorOpIn {
+				nodes = append(nodes, expr.Values...)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// storageClassIndexFunc indexes a PV by its storage class name.
+func storageClassIndexFunc(obj interface{}) ([]string, error) {
+	pv, ok := obj.(*v1.PersistentVolume)
+	if !ok {
+		return nil, fmt.Errorf("object is not a PersistentVolume: %v", obj)
+	}
+	if pv.Spec.StorageClassName == "" {
+		return nil, nil
+	}
+	return []string{pv.Spec.StorageClassName}, nil
+}
+
+// hostPathIndexFunc indexes a PV by its local host path.
+func hostPathIndexFunc(obj interface{}) ([]string, error) {
+	pv, ok := obj.(*v1.PersistentVolume)
+	if !ok {
+		return nil, fmt.Errorf("object is not a PersistentVolume: %v", obj)
+	}
+	if pv.Spec.Local == nil || pv.Spec.Local.Path == "" {
+		return nil, nil
+	}
+	return []string{pv.Spec.Local.Path}, nil
+}
+
+// SetAssumeCache wires an AssumeCache into the VolumeCache so Add/Update/
+// Delete notify it of informer events. Nothing in this source tree calls it.
+func (cache *VolumeCache) SetAssumeCache(assumeCache *AssumeCache) {
+	cache.assumeCache = assumeCache
+}
+
+// SetClaimCache wires a ClaimCache into the VolumeCache so GetBoundPVC can
+// answer which PVC, if any, a PV is bound to. Nothing in this source tree
+// calls it.
+func (cache *VolumeCache) SetClaimCache(claimCache *ClaimCache) {
+	cache.claimCache = claimCache
+}
+
+// GetBoundPVC returns the PVC bound to the PV named pvName, if the
+// VolumeCache has a ClaimCache wired in and that PVC is known to it.
+func (cache *VolumeCache) GetBoundPVC(pvName string) (*v1.PersistentVolumeClaim, bool) {
+	if cache.claimCache == nil {
+		return nil, false
+	}
+	return cache.claimCache.GetPVCByVolumeName(pvName)
+}
+
 // GetPV returns the PV object given the PV name
 func (cache *VolumeCache) GetPV(pvName string) (*v1.PersistentVolume, bool) {
 	obj, exists, _ := cache.pvStore.GetByKey(pvName)
@@ -56,18 +207,21 @@ func (cache *VolumeCache) GetPV(pvName string) (*v1.PersistentVolume, bool) {
 func (cache *VolumeCache) AddPV(pv *v1.PersistentVolume) {
 	cache.pvStore.Add(pv)
 	klog.Infof("Added pv %q to cache", pv.Name)
+	cache.recordMetricsUpsert(pv)
 }
 
 // UpdatePV updates the PV object in the cache
 func (cache *VolumeCache) UpdatePV(pv *v1.PersistentVolume) {
 	cache.pvStore.Update(pv)
 	klog.Infof("Updated pv %q to cache", pv.Name)
+	cache.recordMetricsUpsert(pv)
 }
 
 // DeletePV deletes the PV object from the cache
 func (cache *VolumeCache) DeletePV(pv *v1.PersistentVolume) {
 	cache.pvStore.Delete(pv)
 	klog.Infof("Deleted pv %q from cache", pv.Name)
+	cache.recordMetricsDelete(pv.Name)
 }
 
 // ListPVs returns a list of all the PVs in the cache
@@ -80,6 +234,45 @@ func (cache *VolumeCache) ListPVs() []*v1.PersistentVolume {
 	return pvs
 }
 
+// ListPVsByNode returns the PVs whose node affinity pins them to nodeName.
+func (cache *VolumeCache) ListPVsByNode(nodeName string) ([]*v1.PersistentVolume, error) {
+	objs, err := cache.pvStore.ByIndex(nodeIndex, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	return toPVs(objs), nil
+}
+
+// ListPVsByStorageClass returns the PVs with the given storage class name.
+func (cache *VolumeCache) ListPVsByStorageClass(storageClass string) ([]*v1.PersistentVolume, error) {
+	objs, err := cache.pvStore.ByIndex(storageClassIndex, storageClass)
+	if err != nil {
+		return nil, err
+	}
+	return toPVs(objs), nil
+}
+
+// GetPVByHostPath returns the PV backed by the given local host path, if any.
+func (cache *VolumeCache) GetPVByHostPath(hostPath string) (*v1.PersistentVolume, bool, error) {
+	objs, err := cache.pvStore.ByIndex(hostPathIndex, hostPath)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(objs) == 0 {
+		return nil, false, nil
+	}
+	return objs[0].(*v1.PersistentVolume), true, nil
+}
+
+// toPVs converts a slice of cache objects to typed PVs.
+func toPVs(objs []interface{}) []*v1.PersistentVolume {
+	pvs := make([]*v1.PersistentVolume, 0, len(objs))
+	for _, obj := range objs {
+		pvs = append(pvs, obj.(*v1.PersistentVolume))
+	}
+	return pvs
+}
+
 func (cache *VolumeCache) exists(obj interface{}) bool {
 	key, err := cache.keyFunc(obj)
 	if err != nil {
@@ -92,23 +285,104 @@ func (cache *VolumeCache) exists(obj interface{}) bool {
 	return exists
 }
 
-func (cache *VolumeCache) add(obj interface{}) error {
+// add stores obj in pvStore if it's already known to the cache or passes
+// filterFunc, and reports whether it did so. A PV that fails both checks
+// (a foreign PV this provisioner didn't create) is left out of the cache
+// entirely, so callers must not treat it as admitted for anything else
+// derived from cache contents, such as metrics.
+func (cache *VolumeCache) add(obj interface{}) (bool, error) {
 	if cache.exists(obj) || cache.filterFunc(obj) {
-		return cache.pvStore.Add(obj)
+		return true, cache.pvStore.Add(obj)
 	}
-	return nil
+	return false, nil
 }
 
 func (cache *VolumeCache) Add(obj interface{}) error {
-	return cache.add(obj)
+	admitted, err := cache.add(obj)
+	cache.notifyAssumeCache(obj)
+	if admitted {
+		cache.recordMetricsUpsertObj(obj)
+	}
+	return err
 }
 
 func (cache *VolumeCache) Update(obj interface{}) error {
-	return cache.add(obj)
+	admitted, err := cache.add(obj)
+	cache.notifyAssumeCache(obj)
+	if admitted {
+		cache.recordMetricsUpsertObj(obj)
+	}
+	return err
 }
 
 func (cache *VolumeCache) Delete(obj interface{}) error {
-	return cache.pvStore.Delete(obj)
+	err := cache.pvStore.Delete(obj)
+	if key, keyErr := cache.keyFunc(obj); keyErr == nil {
+		if cache.assumeCache != nil {
+			cache.assumeCache.OnInformerDelete(key)
+		}
+		cache.recordMetricsDelete(key)
+	}
+	return err
+}
+
+// notifyAssumeCache tells the wired AssumeCache, if any, about a PV the
+// informer just added or updated.
+func (cache *VolumeCache) notifyAssumeCache(obj interface{}) {
+	if cache.assumeCache == nil {
+		return
+	}
+	if pv, ok := obj.(*v1.PersistentVolume); ok {
+		cache.assumeCache.OnInformerUpdate(pv)
+	}
+}
+
+// recordMetricsUpsertObj is recordMetricsUpsert for callers, like the
+// cache.Store interface methods, that only have an interface{}.
+func (cache *VolumeCache) recordMetricsUpsertObj(obj interface{}) {
+	if pv, ok := obj.(*v1.PersistentVolume); ok {
+		cache.recordMetricsUpsert(pv)
+	}
+}
+
+// recordMetricsUpsert adjusts PersistentVolumeCount/CapacityBytes for pv
+// being added or changed, decrementing whatever it previously reported (if
+// anything) and incrementing its current state, so the gauges stay accurate
+// without rescanning the whole cache.
+func (cache *VolumeCache) recordMetricsUpsert(pv *v1.PersistentVolume) {
+	newState := pvMetricsStateFor(pv)
+
+	cache.mu.Lock()
+	oldState, existed := cache.metricsState[pv.Name]
+	if existed && oldState == newState {
+		cache.mu.Unlock()
+		return
+	}
+	cache.metricsState[pv.Name] = newState
+	cache.mu.Unlock()
+
+	if existed {
+		metrics.DecPersistentVolumeCount(oldState.mode, oldState.storageClass, v1.PersistentVolumePhase(oldState.phase))
+		metrics.AddCapacityBytes(oldState.storageClass, -float64(oldState.capacity))
+	}
+	metrics.IncPersistentVolumeCount(newState.mode, newState.storageClass, v1.PersistentVolumePhase(newState.phase))
+	metrics.AddCapacityBytes(newState.storageClass, float64(newState.capacity))
+}
+
+// recordMetricsDelete reverses whatever pvName last contributed to
+// PersistentVolumeCount/CapacityBytes.
+func (cache *VolumeCache) recordMetricsDelete(pvName string) {
+	cache.mu.Lock()
+	oldState, existed := cache.metricsState[pvName]
+	if !existed {
+		cache.mu.Unlock()
+		return
+	}
+	delete(cache.metricsState, pvName)
+	cache.mu.Unlock()
+
+	metrics.DecPersistentVolumeCount(oldState.mode, oldState.storageClass, v1.PersistentVolumePhase(oldState.phase))
+	metrics.AddCapacityBytes(oldState.storageClass, -float64(oldState.capacity))
 }
 
 func (cache *VolumeCache) Get(obj interface{}) (item interface{}, exists bool, err error) {
@@ -134,9 +408,50 @@ func (cache *VolumeCache) Replace(objs []interface{}, resourceVersion string) er
 			pvObjs = append(pvObjs, obj)
 		}
 	}
-	return cache.pvStore.Replace(pvObjs, resourceVersion)
+	err := cache.pvStore.Replace(pvObjs, resourceVersion)
+	// The informer's list is now authoritative; any snapshot loaded at
+	// startup has served its purpose.
+	cache.mu.Lock()
+	cache.snapshotPaths = nil
+	cache.mu.Unlock()
+	cache.UpdateMetrics()
+	return err
 }
 
 func (cache *VolumeCache) Resync() error {
 	return cache.pvStore.Resync()
 }
+
+// UpdateMetrics recomputes the persistentvolume_count and capacity_bytes
+// gauges from the current contents of the cache and rebuilds metricsState to
+// match, so the incremental adjustments made by Add/Update/Delete going
+// forward start from an accurate baseline. Add/Update/Delete already keep
+// the gauges current as events arrive; this full rescan exists as a
+// once-per-resync correctness backstop (called from Replace) that also
+// resets any label combination that no longer appears in the cache, rather
+// than leaving it at its last reported value forever.
+func (cache *VolumeCache) UpdateMetrics() {
+	state := make(map[string]pvMetricsState)
+	counts := map[pvMetricsState]int{}
+	capacity := map[string]int64{}
+	for _, pv := range cache.ListPVs() {
+		s := pvMetricsStateFor(pv)
+		state[pv.Name] = s
+		counts[pvMetricsState{mode: s.mode, storageClass: s.storageClass, phase: s.phase}]++
+		capacity[s.storageClass] += s.capacity
+	}
+
+	cache.mu.Lock()
+	cache.metricsState = state
+	cache.mu.Unlock()
+
+	metrics.PersistentVolumeCount.Reset()
+	for key, count := range counts {
+		metrics.SetPersistentVolumeCount(key.mode, key.storageClass, v1.PersistentVolumePhase(key.phase), float64(count))
+	}
+
+	metrics.CapacityBytes.Reset()
+	for sc, bytes := range capacity {
+		metrics.SetCapacityBytes(sc, float64(bytes))
+	}
+}
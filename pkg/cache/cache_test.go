@@ -0,0 +1,194 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestIndexedPV(name, node, storageClass, hostPath string) *v1.PersistentVolume {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			StorageClassName: storageClass,
+		},
+	}
+	if hostPath != "" {
+		pv.Spec.PersistentVolumeSource = v1.PersistentVolumeSource{
+			Local: &v1.LocalVolumeSource{Path: hostPath},
+		}
+	}
+	if node != "" {
+		pv.Spec.NodeAffinity = &v1.VolumeNodeAffinity{
+			Required: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{
+					{
+						MatchExpressions: []v1.NodeSelectorRequirement{
+							{
+								Key:      nodeLabelKey,
+								Operator: v1.NodeSelectorOpIn,
+								Values:   []string{node},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	return pv
+}
+
+func TestNodeIndexFunc(t *testing.T) {
+	pv := newTestIndexedPV("pv1", "node1", "", "")
+	got, err := nodeIndexFunc(pv)
+	if err != nil {
+		t.Fatalf("nodeIndexFunc: %v", err)
+	}
+	if len(got) != 1 || got[0] != "node1" {
+		t.Fatalf("nodeIndexFunc = %v, want [node1]", got)
+	}
+
+	noAffinity := newTestIndexedPV("pv2", "", "", "")
+	got, err = nodeIndexFunc(noAffinity)
+	if err != nil {
+		t.Fatalf("nodeIndexFunc: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("nodeIndexFunc = %v, want none", got)
+	}
+
+	if _, err := nodeIndexFunc("not a pv"); err == nil {
+		t.Fatalf("nodeIndexFunc: expected an error for a non-PV object")
+	}
+}
+
+func TestStorageClassIndexFunc(t *testing.T) {
+	pv := newTestIndexedPV("pv1", "", "local-fast", "")
+	got, err := storageClassIndexFunc(pv)
+	if err != nil {
+		t.Fatalf("storageClassIndexFunc: %v", err)
+	}
+	if len(got) != 1 || got[0] != "local-fast" {
+		t.Fatalf("storageClassIndexFunc = %v, want [local-fast]", got)
+	}
+
+	noClass := newTestIndexedPV("pv2", "", "", "")
+	got, err = storageClassIndexFunc(noClass)
+	if err != nil {
+		t.Fatalf("storageClassIndexFunc: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("storageClassIndexFunc = %v, want none", got)
+	}
+
+	if _, err := storageClassIndexFunc("not a pv"); err == nil {
+		t.Fatalf("storageClassIndexFunc: expected an error for a non-PV object")
+	}
+}
+
+func TestHostPathIndexFunc(t *testing.T) {
+	pv := newTestIndexedPV("pv1", "", "", "/mnt/disks/vol1")
+	got, err := hostPathIndexFunc(pv)
+	if err != nil {
+		t.Fatalf("hostPathIndexFunc: %v", err)
+	}
+	if len(got) != 1 || got[0] != "/mnt/disks/vol1" {
+		t.Fatalf("hostPathIndexFunc = %v, want [/mnt/disks/vol1]", got)
+	}
+
+	noPath := newTestIndexedPV("pv2", "", "", "")
+	got, err = hostPathIndexFunc(noPath)
+	if err != nil {
+		t.Fatalf("hostPathIndexFunc: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("hostPathIndexFunc = %v, want none", got)
+	}
+
+	if _, err := hostPathIndexFunc("not a pv"); err == nil {
+		t.Fatalf("hostPathIndexFunc: expected an error for a non-PV object")
+	}
+}
+
+func TestVolumeCacheListPVsByNode(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	pv1 := newTestIndexedPV("pv1", "node1", "", "")
+	pv2 := newTestIndexedPV("pv2", "node1", "", "")
+	pv3 := newTestIndexedPV("pv3", "node2", "", "")
+	store.AddPV(pv1)
+	store.AddPV(pv2)
+	store.AddPV(pv3)
+
+	got, err := store.ListPVsByNode("node1")
+	if err != nil {
+		t.Fatalf("ListPVsByNode: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("ListPVsByNode(node1) = %v, want 2 PVs", got)
+	}
+
+	got, err = store.ListPVsByNode("missing")
+	if err != nil {
+		t.Fatalf("ListPVsByNode: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("ListPVsByNode(missing) = %v, want none", got)
+	}
+}
+
+func TestVolumeCacheListPVsByStorageClass(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	pv1 := newTestIndexedPV("pv1", "", "local-fast", "")
+	pv2 := newTestIndexedPV("pv2", "", "local-slow", "")
+	store.AddPV(pv1)
+	store.AddPV(pv2)
+
+	got, err := store.ListPVsByStorageClass("local-fast")
+	if err != nil {
+		t.Fatalf("ListPVsByStorageClass: %v", err)
+	}
+	if len(got) != 1 || got[0] != pv1 {
+		t.Fatalf("ListPVsByStorageClass(local-fast) = %v, want [%v]", got, pv1)
+	}
+}
+
+func TestVolumeCacheGetPVByHostPath(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	pv := newTestIndexedPV("pv1", "", "", "/mnt/disks/vol1")
+	store.AddPV(pv)
+
+	got, found, err := store.GetPVByHostPath("/mnt/disks/vol1")
+	if err != nil {
+		t.Fatalf("GetPVByHostPath: %v", err)
+	}
+	if !found || got != pv {
+		t.Fatalf("GetPVByHostPath(/mnt/disks/vol1) = %v, %v, want %v, true", got, found, pv)
+	}
+
+	got, found, err = store.GetPVByHostPath("/mnt/disks/missing")
+	if err != nil {
+		t.Fatalf("GetPVByHostPath: %v", err)
+	}
+	if found {
+		t.Fatalf("GetPVByHostPath(/mnt/disks/missing) = %v, %v, want nil, false", got, found)
+	}
+}
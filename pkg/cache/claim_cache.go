@@ -0,0 +1,134 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// claimStorageClassIndex indexes PVCs by their requested storage class.
+	claimStorageClassIndex = "storageClass"
+	// claimVolumeNameIndex indexes PVCs by the name of the PV they're bound to.
+	claimVolumeNameIndex = "volumeName"
+)
+
+// ClaimCache keeps the PersistentVolumeClaims bound to PVs created by this
+// provisioner: PVCs whose Spec.VolumeName refers to a PV in the VolumeCache,
+// as a PVC informer would deliver them to AddPVC/UpdatePVC/DeletePVC.
+//
+// Its lookups let a caller distinguish a PV that is Released because its PVC
+// was deleted from one whose PVC still exists but is bound to a different PV
+// out-of-band, and tell which claim, if any, a pending local volume is
+// waiting on.
+//
+// Both halves of ClaimCache exist on their own: AddPVC/UpdatePVC/DeletePVC
+// can be driven by a PVC informer, and GetPVCByVolumeName is already wired
+// through VolumeCache.GetBoundPVC for readers. What's missing is the
+// informer itself — there is no PVC informer (and no Populator) in this
+// source tree, so nothing ever calls AddPVC/UpdatePVC/DeletePVC or
+// VolumeCache.SetClaimCache, and a PV/PVC binding change never reaches this
+// cache. A second PVC informer alongside the PV one is the remaining piece.
+type ClaimCache struct {
+	pvcStore cache.Indexer
+	keyFunc  cache.KeyFunc
+}
+
+// NewClaimCache creates a new, empty ClaimCache.
+func NewClaimCache() *ClaimCache {
+	keyFunc := cache.DeletionHandlingMetaNamespaceKeyFunc
+	return &ClaimCache{
+		pvcStore: cache.NewIndexer(keyFunc, cache.Indexers{
+			claimStorageClassIndex: pvcStorageClassIndexFunc,
+			claimVolumeNameIndex:   pvcVolumeNameIndexFunc,
+		}),
+		keyFunc: keyFunc,
+	}
+}
+
+// pvcStorageClassIndexFunc indexes a PVC by its requested storage class.
+func pvcStorageClassIndexFunc(obj interface{}) ([]string, error) {
+	pvc, ok := obj.(*v1.PersistentVolumeClaim)
+	if !ok {
+		return nil, fmt.Errorf("object is not a PersistentVolumeClaim: %v", obj)
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return nil, nil
+	}
+	return []string{*pvc.Spec.StorageClassName}, nil
+}
+
+// pvcVolumeNameIndexFunc indexes a PVC by the name of the PV it's bound to.
+func pvcVolumeNameIndexFunc(obj interface{}) ([]string, error) {
+	pvc, ok := obj.(*v1.PersistentVolumeClaim)
+	if !ok {
+		return nil, fmt.Errorf("object is not a PersistentVolumeClaim: %v", obj)
+	}
+	if pvc.Spec.VolumeName == "" {
+		return nil, nil
+	}
+	return []string{pvc.Spec.VolumeName}, nil
+}
+
+// AddPVC adds the PVC object to the cache.
+func (c *ClaimCache) AddPVC(pvc *v1.PersistentVolumeClaim) {
+	c.pvcStore.Add(pvc)
+	klog.Infof("Added pvc %q to claim cache", pvcKey(pvc))
+}
+
+// UpdatePVC updates the PVC object in the cache.
+func (c *ClaimCache) UpdatePVC(pvc *v1.PersistentVolumeClaim) {
+	c.pvcStore.Update(pvc)
+	klog.Infof("Updated pvc %q in claim cache", pvcKey(pvc))
+}
+
+// DeletePVC deletes the PVC object from the cache.
+func (c *ClaimCache) DeletePVC(pvc *v1.PersistentVolumeClaim) {
+	c.pvcStore.Delete(pvc)
+	klog.Infof("Deleted pvc %q from claim cache", pvcKey(pvc))
+}
+
+// GetPVCByVolumeName returns the PVC bound to the PV named pvName, if any.
+func (c *ClaimCache) GetPVCByVolumeName(pvName string) (*v1.PersistentVolumeClaim, bool) {
+	objs, err := c.pvcStore.ByIndex(claimVolumeNameIndex, pvName)
+	if err != nil || len(objs) == 0 {
+		return nil, false
+	}
+	return objs[0].(*v1.PersistentVolumeClaim), true
+}
+
+// ListPVCsForStorageClass returns the PVCs requesting the given storage class.
+func (c *ClaimCache) ListPVCsForStorageClass(storageClass string) []*v1.PersistentVolumeClaim {
+	objs, err := c.pvcStore.ByIndex(claimStorageClassIndex, storageClass)
+	if err != nil {
+		return nil
+	}
+	pvcs := make([]*v1.PersistentVolumeClaim, 0, len(objs))
+	for _, obj := range objs {
+		pvcs = append(pvcs, obj.(*v1.PersistentVolumeClaim))
+	}
+	return pvcs
+}
+
+// pvcKey returns the namespace/name key klog messages use to identify a PVC.
+func pvcKey(pvc *v1.PersistentVolumeClaim) string {
+	return pvc.Namespace + "/" + pvc.Name
+}
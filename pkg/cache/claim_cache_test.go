@@ -0,0 +1,169 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestPVC(namespace, name, storageClass, volumeName string) *v1.PersistentVolumeClaim {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			VolumeName: volumeName,
+		},
+	}
+	if storageClass != "" {
+		pvc.Spec.StorageClassName = &storageClass
+	}
+	return pvc
+}
+
+func TestPvcStorageClassIndexFunc(t *testing.T) {
+	pvc := newTestPVC("default", "pvc1", "local-fast", "")
+	got, err := pvcStorageClassIndexFunc(pvc)
+	if err != nil {
+		t.Fatalf("pvcStorageClassIndexFunc: %v", err)
+	}
+	if len(got) != 1 || got[0] != "local-fast" {
+		t.Fatalf("pvcStorageClassIndexFunc = %v, want [local-fast]", got)
+	}
+
+	noClass := newTestPVC("default", "pvc2", "", "")
+	got, err = pvcStorageClassIndexFunc(noClass)
+	if err != nil {
+		t.Fatalf("pvcStorageClassIndexFunc: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("pvcStorageClassIndexFunc = %v, want none", got)
+	}
+
+	if _, err := pvcStorageClassIndexFunc("not a pvc"); err == nil {
+		t.Fatalf("pvcStorageClassIndexFunc: expected an error for a non-PVC object")
+	}
+}
+
+func TestPvcVolumeNameIndexFunc(t *testing.T) {
+	pvc := newTestPVC("default", "pvc1", "", "pv1")
+	got, err := pvcVolumeNameIndexFunc(pvc)
+	if err != nil {
+		t.Fatalf("pvcVolumeNameIndexFunc: %v", err)
+	}
+	if len(got) != 1 || got[0] != "pv1" {
+		t.Fatalf("pvcVolumeNameIndexFunc = %v, want [pv1]", got)
+	}
+
+	unbound := newTestPVC("default", "pvc2", "", "")
+	got, err = pvcVolumeNameIndexFunc(unbound)
+	if err != nil {
+		t.Fatalf("pvcVolumeNameIndexFunc: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("pvcVolumeNameIndexFunc = %v, want none", got)
+	}
+
+	if _, err := pvcVolumeNameIndexFunc("not a pvc"); err == nil {
+		t.Fatalf("pvcVolumeNameIndexFunc: expected an error for a non-PVC object")
+	}
+}
+
+func TestClaimCacheGetPVCByVolumeName(t *testing.T) {
+	claimCache := NewClaimCache()
+
+	if _, found := claimCache.GetPVCByVolumeName("pv1"); found {
+		t.Fatalf("GetPVCByVolumeName(pv1): expected not found before AddPVC")
+	}
+
+	pvc := newTestPVC("default", "pvc1", "", "pv1")
+	claimCache.AddPVC(pvc)
+
+	got, found := claimCache.GetPVCByVolumeName("pv1")
+	if !found || got != pvc {
+		t.Fatalf("GetPVCByVolumeName(pv1) = %v, %v, want %v, true", got, found, pvc)
+	}
+
+	claimCache.DeletePVC(pvc)
+	if _, found := claimCache.GetPVCByVolumeName("pv1"); found {
+		t.Fatalf("GetPVCByVolumeName(pv1): expected not found after DeletePVC")
+	}
+}
+
+func TestClaimCacheUpdatePVCChangesVolumeNameIndex(t *testing.T) {
+	claimCache := NewClaimCache()
+
+	pvc := newTestPVC("default", "pvc1", "", "pv1")
+	claimCache.AddPVC(pvc)
+
+	rebound := newTestPVC("default", "pvc1", "", "pv2")
+	claimCache.UpdatePVC(rebound)
+
+	if _, found := claimCache.GetPVCByVolumeName("pv1"); found {
+		t.Fatalf("GetPVCByVolumeName(pv1): expected not found after rebinding to pv2")
+	}
+	got, found := claimCache.GetPVCByVolumeName("pv2")
+	if !found || got != rebound {
+		t.Fatalf("GetPVCByVolumeName(pv2) = %v, %v, want %v, true", got, found, rebound)
+	}
+}
+
+func TestClaimCacheListPVCsForStorageClass(t *testing.T) {
+	claimCache := NewClaimCache()
+
+	fast1 := newTestPVC("default", "pvc1", "local-fast", "")
+	fast2 := newTestPVC("default", "pvc2", "local-fast", "")
+	slow := newTestPVC("default", "pvc3", "local-slow", "")
+	claimCache.AddPVC(fast1)
+	claimCache.AddPVC(fast2)
+	claimCache.AddPVC(slow)
+
+	got := claimCache.ListPVCsForStorageClass("local-fast")
+	if len(got) != 2 {
+		t.Fatalf("ListPVCsForStorageClass(local-fast) = %v, want 2 PVCs", got)
+	}
+
+	if got := claimCache.ListPVCsForStorageClass("missing"); len(got) != 0 {
+		t.Fatalf("ListPVCsForStorageClass(missing) = %v, want none", got)
+	}
+}
+
+func TestVolumeCacheGetBoundPVCWithoutClaimCache(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+
+	if _, found := store.GetBoundPVC("pv1"); found {
+		t.Fatalf("GetBoundPVC(pv1): expected not found without a wired ClaimCache")
+	}
+}
+
+func TestVolumeCacheGetBoundPVC(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+	claimCache := NewClaimCache()
+	store.SetClaimCache(claimCache)
+
+	pvc := newTestPVC("default", "pvc1", "", "pv1")
+	claimCache.AddPVC(pvc)
+
+	got, found := store.GetBoundPVC("pv1")
+	if !found || got != pvc {
+		t.Fatalf("GetBoundPVC(pv1) = %v, %v, want %v, true", got, found, pvc)
+	}
+}
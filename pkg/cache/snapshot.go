@@ -0,0 +1,220 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// snapshotVersion is bumped whenever the Snapshot/SnapshotEntry layout
+// changes in an incompatible way; Load refuses to use a snapshot written by
+// a different version.
+const snapshotVersion = 1
+
+// filesystemUUIDAnnotation records the filesystem UUID of a discovered local
+// volume, if known, so it can be carried into snapshot entries.
+const filesystemUUIDAnnotation = "local.storage.k8s.io/filesystem-uuid"
+
+// SnapshotEntry records what the provisioner knew about a single discovered
+// volume the last time a snapshot was taken.
+type SnapshotEntry struct {
+	PVName         string                  `json:"pvName"`
+	Path           string                  `json:"path"`
+	Capacity       int64                   `json:"capacity"`
+	Mode           v1.PersistentVolumeMode `json:"mode"`
+	DiscoveredAt   time.Time               `json:"discoveredAt"`
+	FilesystemUUID string                  `json:"filesystemUUID,omitempty"`
+}
+
+// Snapshot is the serialized form of a VolumeCache.
+type Snapshot struct {
+	Version int             `json:"version"`
+	Entries []SnapshotEntry `json:"entries"`
+}
+
+// Snapshot builds a Snapshot of the cache's current contents, suitable for
+// persisting with a SnapshotStore.
+func (cache *VolumeCache) Snapshot() *Snapshot {
+	pvs := cache.ListPVs()
+	entries := make([]SnapshotEntry, 0, len(pvs))
+	for _, pv := range pvs {
+		if pv.Spec.Local == nil {
+			continue
+		}
+		mode := v1.PersistentVolumeFilesystem
+		if pv.Spec.VolumeMode != nil {
+			mode = *pv.Spec.VolumeMode
+		}
+		var capacity int64
+		if qty, ok := pv.Spec.Capacity[v1.ResourceStorage]; ok {
+			capacity = qty.Value()
+		}
+		entries = append(entries, SnapshotEntry{
+			PVName:         pv.Name,
+			Path:           pv.Spec.Local.Path,
+			Capacity:       capacity,
+			Mode:           mode,
+			DiscoveredAt:   pv.CreationTimestamp.Time,
+			FilesystemUUID: pv.Annotations[filesystemUUIDAnnotation],
+		})
+	}
+	return &Snapshot{Version: snapshotVersion, Entries: entries}
+}
+
+// LoadSnapshot seeds the cache's host path lookups from a previously
+// persisted Snapshot. This lets the Discoverer call HasDiscoveredPath to
+// avoid recreating a PV for a path it already provisioned, even before the
+// Populator's informer has completed its initial LIST. The snapshot entries
+// are dropped as soon as Replace is called with the informer's
+// authoritative list, see Replace.
+func (cache *VolumeCache) LoadSnapshot(snapshot *Snapshot) {
+	if snapshot == nil {
+		return
+	}
+	paths := make(map[string]string, len(snapshot.Entries))
+	for _, e := range snapshot.Entries {
+		if e.Path != "" {
+			paths[e.Path] = e.PVName
+		}
+	}
+	cache.mu.Lock()
+	cache.snapshotPaths = paths
+	cache.mu.Unlock()
+}
+
+// HasDiscoveredPath reports whether a PV already exists for hostPath,
+// falling back to a loaded snapshot if the informer hasn't synced yet, and
+// returns the owning PV name.
+func (cache *VolumeCache) HasDiscoveredPath(hostPath string) (string, bool) {
+	if pv, exists, _ := cache.GetPVByHostPath(hostPath); exists {
+		return pv.Name, true
+	}
+	cache.mu.Lock()
+	pvName, ok := cache.snapshotPaths[hostPath]
+	cache.mu.Unlock()
+	if ok {
+		return pvName, true
+	}
+	return "", false
+}
+
+// SnapshotStore persists a VolumeCache's Snapshot to disk so a Discoverer
+// can answer "have I already created a PV for this path?" across a
+// provisioner restart without waiting for a full informer resync, instead
+// of the window where a restart racing a slow apiserver causes duplicate PV
+// creation attempts for the same local path.
+//
+// Only the storage mechanism lands here. Nobody in this source tree calls
+// Save periodically, calls LoadSnapshot at startup, or checks
+// HasDiscoveredPath before creating a PV, because there's no
+// Discoverer/Populator startup sequence here to do any of that. Closing the
+// restart race requires that startup code to exist and call into these
+// three methods in order.
+type SnapshotStore interface {
+	// Save atomically persists snapshot, replacing any previously saved one.
+	Save(snapshot *Snapshot) error
+	// Load reads back the most recently saved snapshot. It returns a nil
+	// Snapshot and nil error if none has been saved yet.
+	Load() (*Snapshot, error)
+}
+
+// fileSnapshotStore is a SnapshotStore backed by a single checksummed file,
+// written via a temp-file-plus-rename so readers never observe a partial
+// write.
+type fileSnapshotStore struct {
+	path string
+}
+
+// NewFileSnapshotStore creates a SnapshotStore that persists to
+// filepath.Join(dataDir, "volume-cache-snapshot.json").
+func NewFileSnapshotStore(dataDir string) SnapshotStore {
+	return &fileSnapshotStore{path: filepath.Join(dataDir, "volume-cache-snapshot.json")}
+}
+
+// snapshotFile is the on-disk envelope around a Snapshot: a checksum over
+// the serialized snapshot, computed so Load can detect a corrupted or
+// truncated file.
+type snapshotFile struct {
+	Checksum string   `json:"checksum"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+func (s *fileSnapshotStore) Save(snapshot *Snapshot) error {
+	snapshot.Version = snapshotVersion
+	body, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("marshal snapshot: %v", err)
+	}
+
+	data, err := json.MarshalIndent(snapshotFile{
+		Checksum: checksum(body),
+		Snapshot: *snapshot,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal snapshot file: %v", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("write snapshot temp file %q: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("rename snapshot temp file %q to %q: %v", tmpPath, s.path, err)
+	}
+	return nil
+}
+
+func (s *fileSnapshotStore) Load() (*Snapshot, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot file %q: %v", s.path, err)
+	}
+
+	var file snapshotFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("unmarshal snapshot file %q: %v", s.path, err)
+	}
+
+	body, err := json.Marshal(file.Snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("marshal snapshot for checksum verification: %v", err)
+	}
+	if checksum(body) != file.Checksum {
+		return nil, fmt.Errorf("snapshot file %q failed checksum verification", s.path)
+	}
+	if file.Snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot file %q has version %d, want %d", s.path, file.Snapshot.Version, snapshotVersion)
+	}
+
+	return &file.Snapshot, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%x", sum)
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSnapshot() *Snapshot {
+	return &Snapshot{
+		Version: snapshotVersion,
+		Entries: []SnapshotEntry{
+			{PVName: "pv1", Path: "/mnt/disks/vol1", Capacity: 1024},
+		},
+	}
+}
+
+func TestFileSnapshotStoreSaveLoadRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFileSnapshotStore(dir)
+	want := newTestSnapshot()
+	if err := store.Save(want); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got == nil {
+		t.Fatalf("Load: got nil snapshot, want %+v", want)
+	}
+	if got.Version != want.Version || len(got.Entries) != len(want.Entries) || got.Entries[0] != want.Entries[0] {
+		t.Fatalf("Load = %+v, want %+v", got, want)
+	}
+}
+
+func TestFileSnapshotStoreLoadMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFileSnapshotStore(dir)
+	got, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got != nil {
+		t.Fatalf("Load = %+v, want nil for a store that's never been saved", got)
+	}
+}
+
+func TestFileSnapshotStoreLoadRejectsChecksumMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFileSnapshotStore(dir)
+	if err := store.Save(newTestSnapshot()); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	path := filepath.Join(dir, "volume-cache-snapshot.json")
+	var file snapshotFile
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	file.Snapshot.Entries[0].PVName = "tampered"
+	corrupted, err := json.Marshal(file)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := ioutil.WriteFile(path, corrupted, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if _, err := store.Load(); err == nil {
+		t.Fatalf("Load: expected an error for a tampered snapshot file")
+	}
+}
+
+func TestFileSnapshotStoreLoadRejectsVersionMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFileSnapshotStore(dir)
+	snapshot := newTestSnapshot()
+	snapshot.Version = snapshotVersion + 1
+	if err := store.Save(snapshot); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	// Save always stamps the current snapshotVersion, so reach in and
+	// rewrite the file directly to simulate a snapshot from a future
+	// version.
+	path := filepath.Join(dir, "volume-cache-snapshot.json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var file snapshotFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	file.Snapshot.Version = snapshotVersion + 1
+	body, err := json.Marshal(file.Snapshot)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	file.Checksum = checksum(body)
+	rewritten, err := json.Marshal(file)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := ioutil.WriteFile(path, rewritten, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if _, err := store.Load(); err == nil {
+		t.Fatalf("Load: expected an error for a snapshot with an unsupported version")
+	}
+}
+
+func TestVolumeCacheLoadSnapshotAndHasDiscoveredPath(t *testing.T) {
+	store := NewVolumeCache(alwaysTrue)
+
+	if _, found := store.HasDiscoveredPath("/mnt/disks/vol1"); found {
+		t.Fatalf("HasDiscoveredPath: expected not found before LoadSnapshot")
+	}
+
+	store.LoadSnapshot(newTestSnapshot())
+	pvName, found := store.HasDiscoveredPath("/mnt/disks/vol1")
+	if !found || pvName != "pv1" {
+		t.Fatalf("HasDiscoveredPath = %q, %v, want pv1, true", pvName, found)
+	}
+
+	// Once the informer's initial list is replaced in, the snapshot is
+	// discarded and the real index takes over.
+	if err := store.Replace(nil, "1"); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	if _, found := store.HasDiscoveredPath("/mnt/disks/vol1"); found {
+		t.Fatalf("HasDiscoveredPath: expected not found after Replace discarded the snapshot")
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics exposes Prometheus metrics describing the contents of the
+// VolumeCache and the outcome of the provisioner's discovery/deletion/
+// populate loops.
+//
+// PersistentVolumeCount and CapacityBytes are kept current by VolumeCache
+// itself (see recordMetricsUpsert/recordMetricsDelete/UpdateMetrics in
+// pkg/cache). DiscoveryTotal, DeletionTotal and DeletionDurationSeconds have
+// no caller anywhere in this tree — the Discoverer and Deleter loops that
+// would call RecordDiscovery/RecordDeletion don't exist in this source tree
+// — so they stay permanently at zero. Handler is likewise unregistered: the
+// provisioner binary never serves it at /metrics. Wiring a discovery/delete
+// loop and an HTTP server into main is what would make this package live.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const subsystem = "local_volume_provisioner"
+
+var (
+	// PersistentVolumeCount reports the number of PVs the provisioner knows
+	// about, broken down by volume mode, storage class and phase. VolumeCache
+	// adjusts it as PVs are added, updated and deleted, and recomputes it
+	// from ListPVs on every Replace.
+	PersistentVolumeCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: subsystem,
+		Name:      "persistentvolume_count",
+		Help:      "Number of persistent volumes managed by this provisioner, by volume mode, storage class and phase",
+	}, []string{"mode", "storageclass", "phase"})
+
+	// DiscoveryTotal counts discovery attempts by the Discoverer, by storage
+	// class and result ("success" or "error").
+	DiscoveryTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: subsystem,
+		Name:      "discovery_total",
+		Help:      "Total number of volume discoveries, by storage class and result",
+	}, []string{"storageclass", "result"})
+
+	// DeletionTotal counts deletion attempts by the Deleter, by storage
+	// class, result and volume mode.
+	DeletionTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: subsystem,
+		Name:      "deletion_total",
+		Help:      "Total number of volume deletions, by storage class, result and volume mode",
+	}, []string{"storageclass", "result", "mode"})
+
+	// DeletionDurationSeconds times how long a volume deletion (cleanup plus
+	// PV/API object removal) takes, by storage class and result.
+	DeletionDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: subsystem,
+		Name:      "deletion_duration_seconds",
+		Help:      "Time taken by volume deletions, by storage class and result",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"storageclass", "result"})
+
+	// CapacityBytes sums the capacity of discovered volumes, by storage
+	// class.
+	CapacityBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: subsystem,
+		Name:      "capacity_bytes",
+		Help:      "Total capacity of local volumes discovered by this provisioner, by storage class",
+	}, []string{"storageclass"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		PersistentVolumeCount,
+		DiscoveryTotal,
+		DeletionTotal,
+		DeletionDurationSeconds,
+		CapacityBytes,
+	)
+}
+
+// DiscoveryResult labels a discovery attempt for DiscoveryTotal.
+type DiscoveryResult string
+
+const (
+	// DiscoverySuccess indicates a new PV was successfully created for a
+	// discovered local volume.
+	DiscoverySuccess DiscoveryResult = "success"
+	// DiscoveryError indicates volume discovery or PV creation failed.
+	DiscoveryError DiscoveryResult = "error"
+)
+
+// DeletionResult labels a deletion attempt for DeletionTotal and
+// DeletionDurationSeconds.
+type DeletionResult string
+
+const (
+	// DeletionSuccess indicates the volume was cleaned up and its PV/API
+	// object removed.
+	DeletionSuccess DeletionResult = "success"
+	// DeletionError indicates cleanup or removal failed.
+	DeletionError DeletionResult = "error"
+)
+
+// RecordDiscovery increments DiscoveryTotal for the given storage class and
+// result.
+func RecordDiscovery(storageClass string, result DiscoveryResult) {
+	DiscoveryTotal.WithLabelValues(storageClass, string(result)).Inc()
+}
+
+// RecordDeletion increments DeletionTotal and observes
+// DeletionDurationSeconds for the given storage class, result and volume
+// mode.
+func RecordDeletion(storageClass string, result DeletionResult, mode v1.PersistentVolumeMode, durationSeconds float64) {
+	DeletionTotal.WithLabelValues(storageClass, string(result), string(mode)).Inc()
+	DeletionDurationSeconds.WithLabelValues(storageClass, string(result)).Observe(durationSeconds)
+}
+
+// SetCapacityBytes sets the total discovered capacity for a storage class.
+func SetCapacityBytes(storageClass string, bytes float64) {
+	CapacityBytes.WithLabelValues(storageClass).Set(bytes)
+}
+
+// AddCapacityBytes adds delta (which may be negative) to the discovered
+// capacity for a storage class, for callers that track capacity
+// incrementally instead of recomputing the total from scratch.
+func AddCapacityBytes(storageClass string, delta float64) {
+	CapacityBytes.WithLabelValues(storageClass).Add(delta)
+}
+
+// SetPersistentVolumeCount sets the PV count for a given mode, storage class
+// and phase.
+func SetPersistentVolumeCount(mode, storageClass string, phase v1.PersistentVolumePhase, count float64) {
+	PersistentVolumeCount.WithLabelValues(mode, storageClass, string(phase)).Set(count)
+}
+
+// IncPersistentVolumeCount increments the PV count for a given mode, storage
+// class and phase, for callers that track the count incrementally instead
+// of recomputing it from scratch.
+func IncPersistentVolumeCount(mode, storageClass string, phase v1.PersistentVolumePhase) {
+	PersistentVolumeCount.WithLabelValues(mode, storageClass, string(phase)).Inc()
+}
+
+// DecPersistentVolumeCount decrements the PV count for a given mode, storage
+// class and phase.
+func DecPersistentVolumeCount(mode, storageClass string, phase v1.PersistentVolumePhase) {
+	PersistentVolumeCount.WithLabelValues(mode, storageClass, string(phase)).Dec()
+}
+
+// Handler returns the http.Handler the provisioner binary should register at
+// /metrics to expose these metrics to Prometheus.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}